@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cosmos/cosmos-sdk/cosmovisor"
+)
+
+// NewRestoreCmd returns the `cosmovisor restore <backup>` command, which
+// restores $DAEMON_HOME/data from a backup taken by any of the
+// cosmovisor/backup.Backuper implementations - a plain directory
+// (FSBackuper/RsyncBackuper) or a .tar.zst archive (TarZstdBackuper) -
+// auto-detecting the format (see cosmovisor.Restore).
+//
+// This snapshot doesn't include the root command this should be registered
+// under (cmd/cosmovisor/cmd/root.go); wire it in there with
+// rootCmd.AddCommand(NewRestoreCmd()) alongside run/version/etc.
+func NewRestoreCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "restore [backup-path]",
+		Short: "Restore the data directory from a cosmovisor backup",
+		Long: `Restore $DAEMON_HOME/data from a backup previously taken by cosmovisor,
+auto-detecting whether backup-path is a directory or a .tar.zst archive.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := cosmovisor.GetConfigFromEnv()
+			if err != nil {
+				return err
+			}
+			return cosmovisor.Restore(cfg, args[0])
+		},
+	}
+}
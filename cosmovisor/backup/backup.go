@@ -0,0 +1,45 @@
+// Package backup provides pluggable strategies for snapshotting a chain's
+// data directory ahead of an upgrade, plus a retention pruner for the
+// backups they leave behind.
+package backup
+
+import (
+	"fmt"
+	"time"
+)
+
+// ProgressFunc is called periodically during a backup with the cumulative
+// number of bytes copied, so a caller can surface progress for
+// multi-hundred-GB mainnet data directories instead of going silent until
+// the whole thing finishes.
+type ProgressFunc func(bytesCopied int64)
+
+// Backuper snapshots srcDir into a backup under destDir.
+type Backuper interface {
+	// Name identifies the backend, e.g. in Config.BackupBackend and log output.
+	Name() string
+	// Backup snapshots srcDir under destDir, reporting progress via
+	// progress if non-nil, and returns the path it produced.
+	Backup(srcDir, destDir string, progress ProgressFunc) (string, error)
+}
+
+// New returns the Backuper named by backend ("fs", "tar.zst", or "rsync"),
+// defaulting to "fs" (the original cosmovisor behavior) if backend is empty.
+func New(backend string) (Backuper, error) {
+	switch backend {
+	case "", "fs":
+		return FSBackuper{}, nil
+	case "tar.zst":
+		return TarZstdBackuper{}, nil
+	case "rsync":
+		return RsyncBackuper{}, nil
+	default:
+		return nil, fmt.Errorf("unknown backup backend %q", backend)
+	}
+}
+
+// stamp formats t as YYYY-M-D, the date part of the data-backup-YYYY-M-D
+// name cosmovisor has always given its backups.
+func stamp(t time.Time) string {
+	return fmt.Sprintf("%d-%d-%d", t.Year(), t.Month(), t.Day())
+}
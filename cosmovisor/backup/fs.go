@@ -0,0 +1,77 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FSBackuper is the original cosmovisor backup strategy: a plain recursive
+// copy of the data directory into data-backup-YYYY-M-D. It needs no external
+// binary, but for large LevelDB/RocksDB data directories it is both slower
+// and larger on disk than TarZstdBackuper or RsyncBackuper.
+type FSBackuper struct{}
+
+func (FSBackuper) Name() string { return "fs" }
+
+func (FSBackuper) Backup(srcDir, destDir string, progress ProgressFunc) (string, error) {
+	dst := filepath.Join(destDir, "data-backup-"+stamp(time.Now()))
+
+	var copied int64
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			n, err := copyFile(path, target, info.Mode())
+			copied += n
+			if progress != nil {
+				progress(copied)
+			}
+			return err
+		}
+	})
+	if err != nil {
+		return "", fmt.Errorf("copying %s to %s: %w", srcDir, dst, err)
+	}
+
+	return dst, nil
+}
+
+func copyFile(src, dst string, mode os.FileMode) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return 0, err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	return io.Copy(out, in)
+}
@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/otiai10/copy"
+)
+
+// Restore writes the contents of the backup at src back into destDir,
+// auto-detecting the format from src: a .tar.zst archive produced by
+// TarZstdBackuper, or a plain directory produced by FSBackuper/RsyncBackuper.
+// It is the implementation behind the `cosmovisor restore` CLI command.
+func Restore(src, destDir string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("reading backup %s: %w", src, err)
+	}
+
+	if info.IsDir() {
+		return copy.Copy(src, destDir)
+	}
+
+	if filepath.Ext(src) != ".zst" {
+		return fmt.Errorf("unrecognized backup format %s", src)
+	}
+
+	return restoreTarZst(src, destDir)
+}
+
+func restoreTarZst(src, destDir string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("opening zstd stream: %w", err)
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading archive %s: %w", src, err)
+		}
+
+		target := filepath.Join(destDir, hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(hdr.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := extractTarFile(tr, target, os.FileMode(hdr.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			_ = os.Remove(target) // clear any stale entry so Symlink doesn't fail with EEXIST
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return fmt.Errorf("restoring symlink %s: %w", target, err)
+			}
+		}
+	}
+}
+
+func extractTarFile(r io.Reader, target string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, r)
+	return err
+}
@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Retention describes how many backups to keep after a successful backup
+// completes. If both are set, KeepLast takes precedence over KeepDays.
+type Retention struct {
+	KeepLast int
+	KeepDays int
+}
+
+// Prune removes backups under destDir beyond what r allows. Backups are
+// matched by the data-backup-* naming convention shared by every Backuper,
+// so it prunes across backends even if Config.BackupBackend changed since
+// the oldest backup was taken.
+func Prune(destDir string, r Retention) error {
+	entries, err := backupEntriesByAge(destDir)
+	if err != nil {
+		return err
+	}
+
+	var toRemove []string
+	switch {
+	case r.KeepLast > 0:
+		if len(entries) > r.KeepLast {
+			toRemove = entries[:len(entries)-r.KeepLast]
+		}
+	case r.KeepDays > 0:
+		cutoff := time.Now().AddDate(0, 0, -r.KeepDays)
+		for _, e := range entries {
+			if modTime(e).Before(cutoff) {
+				toRemove = append(toRemove, e)
+			}
+		}
+	}
+
+	for _, path := range toRemove {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("pruning backup %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func backupEntriesByAge(destDir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(destDir, "data-backup-*"))
+	if err != nil {
+		return nil, fmt.Errorf("listing backups in %s: %w", destDir, err)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return modTime(matches[i]).Before(modTime(matches[j]))
+	})
+	return matches, nil
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,111 @@
+package backup
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RsyncBackuper shells out to rsync with --link-dest against the previous
+// backup, so a new backup only uses extra disk for files that changed since
+// last time - everything else is hard-linked. Requires rsync on PATH.
+type RsyncBackuper struct{}
+
+func (RsyncBackuper) Name() string { return "rsync" }
+
+func (RsyncBackuper) Backup(srcDir, destDir string, progress ProgressFunc) (string, error) {
+	dst := filepath.Join(destDir, "data-backup-"+stamp(time.Now()))
+
+	args := []string{"-a", "--info=progress2"}
+	if prev, ok := latestBackupDir(destDir); ok {
+		args = append(args, "--link-dest="+prev)
+	}
+	args = append(args, srcDir+string(os.PathSeparator), dst+string(os.PathSeparator))
+
+	cmd := exec.Command("rsync", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("starting rsync: %w", err)
+	}
+
+	if progress != nil {
+		go scanRsyncProgress(stdout, progress)
+	} else {
+		go func() { _, _ = io.Copy(ioutil.Discard, stdout) }()
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return "", fmt.Errorf("rsync failed: %w", err)
+	}
+
+	return dst, nil
+}
+
+// latestBackupDir returns the most recent data-backup-* directory (not
+// archive) under destDir, for use as rsync's --link-dest.
+func latestBackupDir(destDir string) (string, bool) {
+	matches, err := filepath.Glob(filepath.Join(destDir, "data-backup-*"))
+	if err != nil {
+		return "", false
+	}
+
+	var dirs []string
+	for _, m := range matches {
+		if info, err := os.Stat(m); err == nil && info.IsDir() {
+			dirs = append(dirs, m)
+		}
+	}
+	if len(dirs) == 0 {
+		return "", false
+	}
+
+	sort.Slice(dirs, func(i, j int) bool {
+		return modTime(dirs[i]).Before(modTime(dirs[j]))
+	})
+	return dirs[len(dirs)-1], true
+}
+
+// rsyncBytesPattern pulls the leading byte count off an rsync
+// --info=progress2 line, e.g. "     12,345,678  45%   10.2MB/s    0:00:05".
+var rsyncBytesPattern = regexp.MustCompile(`^\s*([\d,]+)\s`)
+
+func scanRsyncProgress(r io.Reader, progress ProgressFunc) {
+	scanner := bufio.NewScanner(r)
+	// --info=progress2 redraws its line in place with '\r', so split on that
+	// as well as '\n' to see each update rather than only the final one.
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		for i, b := range data {
+			if b == '\r' || b == '\n' {
+				return i + 1, data[:i], nil
+			}
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	for scanner.Scan() {
+		m := rsyncBytesPattern.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.ReplaceAll(m[1], ",", ""), 10, 64)
+		if err != nil {
+			continue
+		}
+		progress(n)
+	}
+}
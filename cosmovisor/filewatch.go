@@ -0,0 +1,92 @@
+package cosmovisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Config.UpgradeDetection values. UpgradeDetectionBoth is the default: it
+// runs the legacy log scanners alongside the upgrade-info.json watcher, and
+// whichever notices the upgrade first wins.
+const (
+	UpgradeDetectionLog  = "log"
+	UpgradeDetectionFile = "file"
+	UpgradeDetectionBoth = "both"
+)
+
+// waitForUpgradeFile watches $DAEMON_HOME/data/upgrade-info.json and returns
+// the first valid UpgradeInfo written to it, or (nil, nil) if stop is closed
+// first - the caller closes stop once the child has exited some other way
+// (log-scanner detection, a crash, a normal exit) so this watcher, and the
+// inotify instance backing it, don't leak for the rest of cosmovisor's
+// lifetime. Unlike scanning stdout/stderr for the legacy "UPGRADE_NEEDED" log
+// line, this doesn't care whether the chain logs in plain text or JSON, and
+// doesn't need cfg.LogBufferSize raised to accommodate very long log lines.
+func waitForUpgradeFile(path string, stop <-chan struct{}) (*UpgradeInfo, error) {
+	// the file may already be there, e.g. cosmovisor was restarted after the
+	// plan was written but before it noticed - check before watching.
+	if info, ok := readUpgradeInfoFile(path); ok {
+		return info, nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("creating upgrade-info.json watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	dir := filepath.Dir(path)
+	if err := watcher.Add(dir); err != nil {
+		return nil, fmt.Errorf("watching %s: %w", dir, err)
+	}
+
+	// re-check after Add in case the file appeared between our first read
+	// and the watch being registered.
+	if info, ok := readUpgradeInfoFile(path); ok {
+		return info, nil
+	}
+
+	for {
+		select {
+		case <-stop:
+			return nil, nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil, fmt.Errorf("upgrade-info.json watcher on %s closed unexpectedly", dir)
+			}
+			if filepath.Clean(event.Name) != filepath.Clean(path) {
+				continue
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+				continue
+			}
+			if info, ok := readUpgradeInfoFile(path); ok {
+				return info, nil
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil, fmt.Errorf("upgrade-info.json watcher on %s closed unexpectedly", dir)
+			}
+			return nil, fmt.Errorf("watching %s: %w", dir, err)
+		}
+	}
+}
+
+func readUpgradeInfoFile(path string) (*UpgradeInfo, bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	var info UpgradeInfo
+	if err := json.Unmarshal(data, &info); err != nil || info.Name == "" {
+		return nil, false
+	}
+	return &info, true
+}
@@ -0,0 +1,142 @@
+package cosmovisor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/cosmovisor/handoff"
+)
+
+// handoffTimeout bounds how long cosmovisor waits for an outgoing child to
+// complete the listener handoff handshake after receiving SIGTERM, before
+// giving up and hard-killing it. Binaries that don't opt into
+// handoff.AdoptListener simply never dial the control socket, so this also
+// bounds the extra shutdown latency graceful restart adds for them.
+const handoffTimeout = 10 * time.Second
+
+// handoffSockPath is where cosmovisor listens for the SCM_RIGHTS handshake
+// described in the handoff package, relative to the daemon home.
+func handoffSockPath(cfg *Config) string {
+	return filepath.Join(cfg.Home, "cosmovisor", "handoff.sock")
+}
+
+// pendingHandoff carries listeners received from an outgoing child across to
+// the next LaunchProcess call, which passes them to the new child via
+// ExtraFiles. cosmovisor runs the upgrade loop sequentially in a single
+// process, so a package-level handoff is enough - there is never more than
+// one pending set at a time.
+var pendingHandoff struct {
+	mutex     sync.Mutex
+	listeners map[string]net.Listener
+}
+
+func takePendingHandoff() map[string]net.Listener {
+	pendingHandoff.mutex.Lock()
+	defer pendingHandoff.mutex.Unlock()
+	out := pendingHandoff.listeners
+	pendingHandoff.listeners = nil
+	return out
+}
+
+func setPendingHandoff(listeners map[string]net.Listener) {
+	pendingHandoff.mutex.Lock()
+	defer pendingHandoff.mutex.Unlock()
+	pendingHandoff.listeners = listeners
+}
+
+// prepareGracefulRestart wires cmd up for a graceful-restart-aware launch:
+// any listeners handed off by the previous child are attached as inherited
+// file descriptors, and the control socket's path is passed to this child in
+// COSMOVISOR_HANDOFF_SOCK for use when its own turn to hand off comes. The
+// socket itself is only bound later, by stopForUpgrade.
+func prepareGracefulRestart(cfg *Config, cmd *exec.Cmd) error {
+	files, env, err := handoff.FilesForExec(takePendingHandoff())
+	if err != nil {
+		return fmt.Errorf("attaching inherited listeners: %w", err)
+	}
+	if len(files) > 0 {
+		cmd.ExtraFiles = files
+		cmd.Env = append(os.Environ(), env...)
+		// LISTEN_PID would normally be the pid of the process receiving the
+		// fds, but we don't know the child's pid until after Start. Since
+		// cosmovisor is the only process expected to use these fds, and it
+		// controls both ends of the handoff, handoff.inheritedListeners does
+		// not enforce a match against this value - it's set only for parity
+		// with the systemd socket-activation convention.
+		cmd.Env = append(cmd.Env, handoff.EnvListenPID+"=0")
+	} else {
+		cmd.Env = os.Environ()
+	}
+
+	sockPath := handoffSockPath(cfg)
+	if err := os.MkdirAll(filepath.Dir(sockPath), 0o755); err != nil {
+		return fmt.Errorf("creating cosmovisor data dir: %w", err)
+	}
+	cmd.Env = append(cmd.Env, "COSMOVISOR_HANDOFF_SOCK="+sockPath)
+
+	return nil
+}
+
+// stopForUpgrade ends the running child ahead of an upgrade. With graceful
+// restart enabled it sends SIGTERM and gives the child up to handoffTimeout
+// to dial the control socket and hand its listeners over via
+// handoff.SendFDs; those listeners are stashed for the next LaunchProcess
+// call. Without graceful restart, or if the handshake doesn't complete in
+// time, it falls back to killing the child outright.
+func stopForUpgrade(cfg *Config, cmd *exec.Cmd) error {
+	if !cfg.GracefulRestart {
+		return signalGroup(cmd, syscall.SIGKILL)
+	}
+
+	sockPath := handoffSockPath(cfg)
+
+	// bind the control socket before signaling the child at all: otherwise a
+	// child that dials the instant it sees SIGTERM can race AcceptFDs's own
+	// setup and hit ECONNREFUSED, silently degrading the handoff to a hard
+	// kill.
+	ln, err := handoff.ListenForHandoff(sockPath)
+	if err != nil {
+		return signalGroup(cmd, syscall.SIGKILL)
+	}
+
+	// bounds how long AcceptFDs waits in Accept; cancel unblocks and tears it
+	// down on every return path below, including the timeout one, so a child
+	// that never dials the socket doesn't leak the listener and goroutine.
+	ctx, cancel := context.WithTimeout(context.Background(), handoffTimeout)
+	defer cancel()
+
+	type result struct {
+		listeners map[string]net.Listener
+		err       error
+	}
+	done := make(chan result, 1)
+	go func() {
+		listeners, err := handoff.AcceptFDs(ctx, sockPath, ln)
+		done <- result{listeners, err}
+	}()
+
+	if err := signalGroup(cmd, syscall.SIGTERM); err != nil {
+		return signalGroup(cmd, syscall.SIGKILL)
+	}
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			// the child exited, or never dialed the socket at all (e.g. it
+			// doesn't call handoff.AdoptListener) - fall back to a hard kill
+			// so the upgrade still proceeds.
+			return signalGroup(cmd, syscall.SIGKILL)
+		}
+		setPendingHandoff(r.listeners)
+		return nil
+	case <-ctx.Done():
+		return signalGroup(cmd, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,56 @@
+package handoff
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FilesForExec converts a set of named listeners into the *os.File slice and
+// environment variables needed to hand them to a freshly exec'd process via
+// cmd.ExtraFiles. The returned files must be appended to cmd.ExtraFiles in
+// order (they land at fd 3, 4, 5, ... in the child), and the returned env
+// lines appended to cmd.Env.
+func FilesForExec(listeners map[string]net.Listener) (files []*os.File, env []string, err error) {
+	if len(listeners) == 0 {
+		return nil, nil, nil
+	}
+
+	names := make([]string, 0, len(listeners))
+	for name := range listeners {
+		names = append(names, name)
+	}
+
+	for _, name := range names {
+		f, err := fileOf(listeners[name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("getting file descriptor for listener %q: %w", name, err)
+		}
+		files = append(files, f)
+	}
+
+	env = []string{
+		EnvListenFDs + "=" + strconv.Itoa(len(files)),
+		EnvListenFDNames + "=" + strings.Join(names, ":"),
+		// LISTEN_PID is left to the caller; the child's pid isn't known
+		// before Start (see prepareGracefulRestart in graceful_restart.go).
+	}
+
+	return files, env, nil
+}
+
+// fileOf extracts the underlying *os.File from a net.Listener so it can be
+// passed across exec as an inherited descriptor.
+func fileOf(l net.Listener) (*os.File, error) {
+	type filer interface {
+		File() (*os.File, error)
+	}
+
+	fl, ok := l.(filer)
+	if !ok {
+		return nil, fmt.Errorf("listener of type %T does not support extracting a file descriptor", l)
+	}
+	return fl.File()
+}
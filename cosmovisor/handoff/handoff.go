@@ -0,0 +1,128 @@
+// Package handoff lets an app being run under cosmovisor keep its listening
+// sockets open across an upgrade. Instead of the new binary re-binding (and
+// racing the old one for the port, or causing a connection gap), cosmovisor
+// collects the listener file descriptors from the exiting binary and passes
+// them to the newly exec'd one using the systemd socket-activation
+// convention: FDs start at 3, and LISTEN_FDS/LISTEN_FDNAMES/LISTEN_PID
+// describe how many there are and what they're called.
+//
+// App authors opt in by calling AdoptListener once per socket at startup,
+// right after net.Listen:
+//
+//	l, err := net.Listen("tcp", addr)
+//	...
+//	l, err = handoff.AdoptListener("rpc", l)
+//
+// On first start AdoptListener just registers l and hands it back unchanged.
+// If cosmovisor restarted the binary with inherited FDs, it instead closes l
+// and returns a net.Listener wrapping the inherited descriptor, so the app
+// never stops accepting connections on that address.
+//
+// AdoptListener only registers listeners; nothing is sent to cosmovisor
+// until the app calls SendFDs from its SIGTERM handling, passing the socket
+// path cosmovisor sets in the COSMOVISOR_HANDOFF_SOCK environment variable.
+package handoff
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// EnvListenFDs is the count of inherited listener FDs, systemd convention.
+	EnvListenFDs = "LISTEN_FDS"
+	// EnvListenFDNames is a colon-separated list of names, one per inherited
+	// FD, in the same order as EnvListenFDs. Non-standard systemd extension
+	// (LISTEN_FDNAMES) that we rely on to match a listener back to the name
+	// it was adopted under.
+	EnvListenFDNames = "LISTEN_FDNAMES"
+	// EnvListenPID would normally be checked against the receiving process's
+	// pid so an inherited FD isn't picked up by the wrong process. Cosmovisor
+	// controls both ends of the handoff directly (unlike systemd, which hands
+	// FDs to an arbitrary unit), so we set it for informational parity with
+	// the convention but don't require an exact match here.
+	EnvListenPID = "LISTEN_PID"
+
+	listenFDsStart = 3
+)
+
+var (
+	mu        sync.Mutex
+	adopted   = map[string]net.Listener{}
+	inherited = inheritedListeners()
+)
+
+// AdoptListener registers l under name so that, if cosmovisor performs a
+// graceful restart later, its file descriptor can be handed to the next
+// binary. If the current process was itself started with an inherited
+// listener under that name, l is closed and the inherited listener is
+// returned instead.
+func AdoptListener(name string, l net.Listener) (net.Listener, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if inh, ok := inherited[name]; ok {
+		if err := l.Close(); err != nil {
+			return nil, fmt.Errorf("closing fresh listener for %q after inheriting fd: %w", name, err)
+		}
+		adopted[name] = inh
+		return inh, nil
+	}
+
+	adopted[name] = l
+	return l, nil
+}
+
+// Listeners returns every listener currently adopted, keyed by name. It is
+// used by cosmovisor itself (not app code) to collect FDs ahead of an
+// upgrade-triggered restart.
+func Listeners() map[string]net.Listener {
+	mu.Lock()
+	defer mu.Unlock()
+	out := make(map[string]net.Listener, len(adopted))
+	for k, v := range adopted {
+		out[k] = v
+	}
+	return out
+}
+
+// inheritedListeners parses LISTEN_FDS/LISTEN_FDNAMES out of the environment
+// and wraps each inherited descriptor in a net.Listener. It is evaluated
+// once at process start, mirroring how systemd-activated services read
+// their sockets before doing anything else.
+func inheritedListeners() map[string]net.Listener {
+	out := map[string]net.Listener{}
+
+	count, err := strconv.Atoi(os.Getenv(EnvListenFDs))
+	if err != nil || count <= 0 {
+		return out
+	}
+
+	names := strings.Split(os.Getenv(EnvListenFDNames), ":")
+
+	for i := 0; i < count; i++ {
+		fd := listenFDsStart + i
+		name := fmt.Sprintf("fd%d", i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(fd), name)
+		if f == nil {
+			continue
+		}
+
+		l, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			continue
+		}
+		out[name] = l
+	}
+
+	return out
+}
@@ -0,0 +1,154 @@
+package handoff
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// maxHandoffFiles bounds how many rights (fds) we'll accept in a single
+// handoff message, as a sanity check against a misbehaving peer.
+const maxHandoffFiles = 32
+
+// SendFDs dials the unix control socket at sockPath and hands every adopted
+// listener across in a single message: the listener names, colon-joined, as
+// the regular payload, and their file descriptors as SCM_RIGHTS ancillary
+// data in the same order. It is called from the outgoing binary's shutdown
+// path once cosmovisor asks for a handoff (see cosmovisor.Config.GracefulRestart).
+func SendFDs(sockPath string) error {
+	listeners := Listeners()
+	if len(listeners) == 0 {
+		return nil
+	}
+
+	conn, err := net.Dial("unix", sockPath)
+	if err != nil {
+		return fmt.Errorf("dialing handoff control socket %s: %w", sockPath, err)
+	}
+	defer conn.Close()
+
+	uconn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("handoff control socket %s is not a unix socket", sockPath)
+	}
+
+	names := make([]string, 0, len(listeners))
+	fds := make([]int, 0, len(listeners))
+	for name, l := range listeners {
+		f, err := fileOf(l)
+		if err != nil {
+			return fmt.Errorf("extracting fd for listener %q: %w", name, err)
+		}
+		defer f.Close()
+		names = append(names, name)
+		fds = append(fds, int(f.Fd()))
+	}
+
+	oob := syscall.UnixRights(fds...)
+	_, _, err = uconn.WriteMsgUnix([]byte(strings.Join(names, ":")), oob, nil)
+	if err != nil {
+		return fmt.Errorf("sending listener fds over %s: %w", sockPath, err)
+	}
+	return nil
+}
+
+// ListenForHandoff binds the control socket at sockPath. Callers must bind
+// this - and only then signal the outgoing child - before calling AcceptFDs
+// in a goroutine: a child that dials the instant it sees SIGTERM can hit
+// ECONNREFUSED if nothing was listening yet, silently degrading the handoff
+// to a hard kill.
+func ListenForHandoff(sockPath string) (*net.UnixListener, error) {
+	_ = os.Remove(sockPath)
+
+	ln, err := net.Listen("unix", sockPath)
+	if err != nil {
+		return nil, fmt.Errorf("listening on handoff control socket %s: %w", sockPath, err)
+	}
+
+	uln, ok := ln.(*net.UnixListener)
+	if !ok {
+		ln.Close()
+		return nil, fmt.Errorf("handoff control socket %s is not a unix listener", sockPath)
+	}
+	return uln, nil
+}
+
+// AcceptFDs accepts a single handoff message on ln (see ListenForHandoff)
+// from the outgoing binary, and returns the inherited listeners keyed by
+// name. It is called from cosmovisor, not app code.
+//
+// Most binaries never dial sockPath at all (they don't call AdoptListener),
+// so the common case is ctx expiring while AcceptFDs is still blocked in
+// Accept - ctx lets the caller (stopForUpgrade's handoffTimeout) give up
+// without leaking this goroutine or the listener behind it.
+func AcceptFDs(ctx context.Context, sockPath string, ln *net.UnixListener) (map[string]net.Listener, error) {
+	defer ln.Close()
+	defer os.Remove(sockPath)
+
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ln.Close() // unblocks the Accept below
+		case <-stopWatch:
+		}
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("accepting handoff connection on %s: %w", sockPath, err)
+	}
+	defer conn.Close()
+
+	uconn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("handoff control socket %s is not a unix socket", sockPath)
+	}
+
+	data := make([]byte, 4096)
+	oob := make([]byte, syscall.CmsgSpace(maxHandoffFiles*4))
+	n, oobn, _, _, err := uconn.ReadMsgUnix(data, oob)
+	if err != nil {
+		return nil, fmt.Errorf("reading handoff message from %s: %w", sockPath, err)
+	}
+
+	names := strings.Split(string(data[:n]), ":")
+
+	cmsgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, fmt.Errorf("parsing handoff control message: %w", err)
+	}
+
+	var fds []int
+	for _, c := range cmsgs {
+		rights, err := syscall.ParseUnixRights(&c)
+		if err != nil {
+			continue
+		}
+		fds = append(fds, rights...)
+	}
+
+	if len(fds) != len(names) {
+		return nil, fmt.Errorf("handoff protocol mismatch: got %d names but %d fds", len(names), len(fds))
+	}
+
+	out := make(map[string]net.Listener, len(fds))
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), names[i])
+		l, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reconstituting listener %q from fd: %w", names[i], err)
+		}
+		out[names[i]] = l
+	}
+
+	return out, nil
+}
@@ -6,17 +6,14 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
-	"os"
 	"os/exec"
-	"os/signal"
 	"path/filepath"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
-	"github.com/otiai10/copy"
+	"github.com/cosmos/cosmos-sdk/cosmovisor/backup"
 )
 
 // LaunchProcess runs a subprocess and returns when the subprocess exits,
@@ -32,6 +29,17 @@ func LaunchProcess(cfg *Config, args []string, stdout, stderr io.Writer) (bool,
 	}
 
 	cmd := exec.Command(bin, args...)
+	// run the child in its own process group so a signal sent to cosmovisor
+	// (e.g. an operator's Ctrl-C on the shell) doesn't also land on the
+	// child directly - cosmovisor decides how and when to forward it instead.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if cfg.GracefulRestart {
+		if err := prepareGracefulRestart(cfg, cmd); err != nil {
+			return false, fmt.Errorf("preparing graceful restart: %w", err)
+		}
+	}
+
 	outpipe, err := cmd.StdoutPipe()
 	if err != nil {
 		return false, err
@@ -60,17 +68,11 @@ func LaunchProcess(cfg *Config, args []string, stdout, stderr io.Writer) (bool,
 		return false, fmt.Errorf("launching process %s %s: %w", bin, strings.Join(args, " "), err)
 	}
 
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGQUIT, syscall.SIGTERM)
-	go func() {
-		sig := <-sigs
-		if err := cmd.Process.Signal(sig); err != nil {
-			log.Fatal(err)
-		}
-	}()
+	stopPrefetch := startPrefetchIfEnabled(cfg)
+	defer stopPrefetch()
 
 	// three ways to exit - command ends, find regexp in scanOut, find regexp in scanErr
-	upgradeInfo, err := WaitForUpgradeOrExit(cmd, scanOut, scanErr)
+	upgradeInfo, err := WaitForUpgradeOrExit(cfg, cmd, scanOut, scanErr)
 	if err != nil {
 		return false, err
 	}
@@ -88,47 +90,70 @@ func LaunchProcess(cfg *Config, args []string, stdout, stderr io.Writer) (bool,
 
 func doBackup(cfg *Config) error {
 	// take backup if `UNSAFE_SKIP_BACKUP` is not set.
-	if !cfg.UnsafeSkipBackup {
-		// check if upgrade-info.json is not empty.
-		var uInfo UpgradeInfo
-		upgradeInfoFile, err := ioutil.ReadFile(filepath.Join(cfg.Home, "data", "upgrade-info.json"))
-		if err != nil {
-			return fmt.Errorf("error while reading upgrade-info.json: %w", err)
-		}
+	if cfg.UnsafeSkipBackup {
+		return nil
+	}
 
-		err = json.Unmarshal(upgradeInfoFile, &uInfo)
-		if err != nil {
-			return err
-		}
+	// check if upgrade-info.json is not empty.
+	var uInfo UpgradeInfo
+	upgradeInfoFile, err := ioutil.ReadFile(filepath.Join(cfg.Home, "data", "upgrade-info.json"))
+	if err != nil {
+		return fmt.Errorf("error while reading upgrade-info.json: %w", err)
+	}
 
-		if uInfo.Name == "" {
-			return fmt.Errorf("upgrade-info.json is empty")
-		}
+	if err := json.Unmarshal(upgradeInfoFile, &uInfo); err != nil {
+		return err
+	}
+
+	if uInfo.Name == "" {
+		return fmt.Errorf("upgrade-info.json is empty")
+	}
+
+	backer, err := backup.New(cfg.BackupBackend)
+	if err != nil {
+		return fmt.Errorf("selecting backup backend: %w", err)
+	}
 
-		// a destination directory, Format YYYY-MM-DD
-		st := time.Now()
-		stStr := fmt.Sprintf("%d-%d-%d", st.Year(), st.Month(), st.Day())
-		dst := filepath.Join(cfg.Home, fmt.Sprintf("data"+"-backup-%s", stStr))
+	st := time.Now()
+	fmt.Printf("starting to take backup of data directory at time %s using the %q backend\n", st, backer.Name())
 
-		fmt.Printf("starting to take backup of data directory at time %s", st)
+	dst, err := backer.Backup(filepath.Join(cfg.Home, "data"), cfg.Home, func(bytesCopied int64) {
+		fmt.Printf("\rbackup progress: %s copied", humanBytes(bytesCopied))
+	})
+	if err != nil {
+		return fmt.Errorf("error while taking data backup: %w", err)
+	}
 
-		// copy the $DAEMON_HOME/data to a backup dir
-		err = copy.Copy(filepath.Join(cfg.Home, "data"), dst)
+	// backup is done, lets check endtime to calculate total time taken for backup process
+	et := time.Now()
+	fmt.Printf("\nbackup saved at location: %s, completed at time: %s\n"+
+		"time taken to complete the backup: %s\n", dst, et, et.Sub(st))
 
-		if err != nil {
-			return fmt.Errorf("error while taking data backup: %w", err)
+	if cfg.BackupRetention.KeepLast > 0 || cfg.BackupRetention.KeepDays > 0 {
+		if err := backup.Prune(cfg.Home, cfg.BackupRetention); err != nil {
+			return fmt.Errorf("error pruning old backups: %w", err)
 		}
-
-		// backup is done, lets check endtime to calculate total time taken for backup process
-		et := time.Now()
-		timeTaken := et.Sub(st)
-		fmt.Printf("backup saved at location: %s, completed at time: %s\n"+
-			"time taken to complete the backup: %s", dst, et, timeTaken)
 	}
 
 	return nil
 }
 
+// humanBytes formats n as a human-readable size (e.g. "1.5MiB"), used to
+// report backup progress for multi-hundred-GB mainnet data directories.
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 // WaitResult is used to wrap feedback on cmd state with some mutex logic.
 // This is needed as multiple go-routines can affect this - two read pipes that can trigger upgrade
 // As well as the command, which can fail
@@ -175,17 +200,55 @@ func (u *WaitResult) SetUpgrade(up *UpgradeInfo) {
 // It returns (nil, err) if the process died by itself, or there was an issue reading the pipes
 // It returns (nil, nil) if the process exited normally without triggering an upgrade. This is very unlikely
 // to happened with "start" but may happened with short-lived commands like `gaiad export ...`
-func WaitForUpgradeOrExit(cmd *exec.Cmd, scanOut, scanErr *bufio.Scanner) (*UpgradeInfo, error) {
+//
+// If cfg.GracefulRestart is set, the child is given a chance to hand its
+// listening sockets off to cosmovisor (see the handoff package) before it is
+// killed, so the next binary started by LaunchProcess can inherit them.
+//
+// cfg.UpgradeDetection controls whether an upgrade is detected by scanning
+// stdout/stderr for a matching log line (the original behavior), by watching
+// upgrade-info.json directly, or both (the default - whichever notices
+// first wins). See the UpgradeDetection* constants.
+//
+// While the child runs, signals received by cosmovisor itself are forwarded
+// to the child's process group (see forwardSignals); SIGINT/SIGTERM trigger
+// a graceful shutdown with a Config.ShutdownGrace timeout before SIGKILL.
+func WaitForUpgradeOrExit(cfg *Config, cmd *exec.Cmd, scanOut, scanErr *bufio.Scanner) (*UpgradeInfo, error) {
 	var res WaitResult
 
+	// cmd.Wait is run exactly once, here, so every other goroutine that needs
+	// to know whether the child has exited (gracefulShutdown, the file
+	// watcher below) observes it via waiter.exited instead of racing Wait's
+	// reaping with a liveness probe of its own.
+	waiter := waitCmd(cmd)
+
+	stopSignalForwarding := forwardSignals(cfg, cmd, &res, waiter.exited)
+	defer stopSignalForwarding()
+
+	detection := cfg.UpgradeDetection
+	if detection == "" {
+		detection = UpgradeDetectionBoth
+	}
+	logDetectionArmed := detection != UpgradeDetectionFile
+
 	waitScan := func(scan *bufio.Scanner) {
 		upgrade, err := WaitForUpdate(scan)
 		if err != nil {
 			res.SetError(err)
-		} else if upgrade != nil {
+			return
+		}
+		// still drain the scanner even when only file detection is armed, so
+		// the child's stdout/stderr pipes never fill up and block it - we
+		// just don't act as though this matched an upgrade.
+		if upgrade != nil && !logDetectionArmed {
+			for scan.Scan() {
+			}
+			return
+		}
+		if upgrade != nil {
 			res.SetUpgrade(upgrade)
 			// now we need to kill the process
-			_ = cmd.Process.Kill()
+			_ = stopForUpgrade(cfg, cmd)
 		}
 	}
 
@@ -193,14 +256,61 @@ func WaitForUpgradeOrExit(cmd *exec.Cmd, scanOut, scanErr *bufio.Scanner) (*Upgr
 	go waitScan(scanOut)
 	go waitScan(scanErr)
 
+	if detection == UpgradeDetectionFile || detection == UpgradeDetectionBoth {
+		stopFileWatch := make(chan struct{})
+		defer close(stopFileWatch)
+
+		go func() {
+			upgrade, err := waitForUpgradeFile(filepath.Join(cfg.Home, "data", "upgrade-info.json"), stopFileWatch)
+			if err != nil {
+				// the log scanners are still watching in "both" mode; only
+				// treat this as fatal when file detection is the only path.
+				if detection == UpgradeDetectionFile {
+					res.SetError(err)
+				}
+				return
+			}
+			if upgrade == nil {
+				// stopFileWatch was closed - cmd.Wait already returned below.
+				return
+			}
+			res.SetUpgrade(upgrade)
+			_ = stopForUpgrade(cfg, cmd)
+		}()
+	}
+
 	// if the command exits normally (eg. short command like `gaiad version`), just return (nil, nil)
 	// we often get broken read pipes if it runs too fast.
 	// if we had upgrade info, we would have killed it, and thus got a non-nil error code
-	err := cmd.Wait()
-	if err == nil {
+	<-waiter.exited
+	if info, _ := res.AsResult(); info != nil {
+		// an upgrade was detected and the child stopped for it; after a
+		// graceful handoff it may well have exited cleanly on SIGTERM.
+		return info, nil
+	}
+	if waiter.err == nil {
 		return nil, nil
 	}
 	// this will set the error code if it wasn't killed due to upgrade
-	res.SetError(err)
+	res.SetError(waiter.err)
 	return res.AsResult()
 }
+
+// cmdWaiter runs cmd.Wait exactly once in the background and publishes the
+// result by closing exited, so multiple goroutines (gracefulShutdown, the
+// upgrade-info.json watcher) can observe that the child has exited without
+// each calling cmd.Wait themselves (only one caller may) or racing a
+// signal-0 liveness probe against Wait's reaping of the process.
+type cmdWaiter struct {
+	exited chan struct{}
+	err    error
+}
+
+func waitCmd(cmd *exec.Cmd) *cmdWaiter {
+	w := &cmdWaiter{exited: make(chan struct{})}
+	go func() {
+		w.err = cmd.Wait()
+		close(w.exited)
+	}()
+	return w
+}
@@ -0,0 +1,15 @@
+package cosmovisor
+
+import (
+	"path/filepath"
+
+	"github.com/cosmos/cosmos-sdk/cosmovisor/backup"
+)
+
+// Restore restores the data directory at cfg.Home from the backup at
+// backupPath, auto-detecting its format (see backup.Restore). This is the
+// implementation behind the sibling `cosmovisor restore <backup>` CLI
+// command.
+func Restore(cfg *Config, backupPath string) error {
+	return backup.Restore(backupPath, filepath.Join(cfg.Home, "data"))
+}
@@ -0,0 +1,119 @@
+package cosmovisor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// defaultShutdownGrace is used when Config.ShutdownGrace is unset.
+const defaultShutdownGrace = 30 * time.Second
+
+// shutdownRequested records whether an operator has asked cosmovisor to stop
+// (SIGINT/SIGTERM), as opposed to the child exiting or crashing on its own.
+// RunAndSuperviseCrashes checks this to avoid restarting a child that was
+// killed on purpose.
+var shutdownRequested int32
+
+func markShutdownRequested() {
+	atomic.StoreInt32(&shutdownRequested, 1)
+}
+
+// ShutdownRequested reports whether an operator has asked cosmovisor to stop.
+func ShutdownRequested() bool {
+	return atomic.LoadInt32(&shutdownRequested) == 1
+}
+
+// forwardSignals relays SIGINT, SIGTERM, SIGQUIT, SIGHUP, SIGUSR1 and SIGUSR2
+// received by cosmovisor to the child's process group, so operator tooling built around signaling the daemon
+// (Tendermint's pprof/reload handlers on SIGUSR1/SIGUSR2, log rotation on
+// SIGHUP, etc.) keeps working unchanged when run under cosmovisor.
+//
+// SIGINT and SIGTERM are special-cased into a graceful shutdown: the child's
+// process group gets SIGTERM, then up to Config.ShutdownGrace (default 30s)
+// to exit on its own before cosmovisor escalates to SIGKILL - the same
+// stop-then-grace-then-kill shape used by most container runtimes stopping
+// a process group. Any error forwarding a signal is recorded on res rather
+// than killing cosmovisor itself; the caller observes it via res.AsResult
+// once cmd.Wait returns.
+//
+// The returned stop func undoes signal.Notify and must be called once the
+// child has exited.
+//
+// exited is closed once cmd.Wait returns (the caller owns running Wait, so
+// gracefulShutdown can tell the child actually exited instead of polling
+// with a signal-0 liveness probe, which races with Wait reaping the process
+// and the pgid being reused).
+func forwardSignals(cfg *Config, cmd *exec.Cmd, res *WaitResult, exited <-chan struct{}) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs,
+		syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT,
+		syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2,
+	)
+
+	done := make(chan struct{})
+	var shutdownOnce sync.Once
+
+	go func() {
+		for {
+			select {
+			case sig, ok := <-sigs:
+				if !ok {
+					return
+				}
+				switch sig {
+				case syscall.SIGINT, syscall.SIGTERM:
+					shutdownOnce.Do(func() {
+						markShutdownRequested()
+						gracefulShutdown(cfg, cmd, exited)
+					})
+				default:
+					if err := signalGroup(cmd, sig.(syscall.Signal)); err != nil {
+						res.SetError(fmt.Errorf("forwarding signal %s to child: %w", sig, err))
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// gracefulShutdown sends SIGTERM to the child's process group and gives it
+// Config.ShutdownGrace to exit - observed via exited, which the caller closes
+// once its single cmd.Wait call returns - before escalating to SIGKILL.
+func gracefulShutdown(cfg *Config, cmd *exec.Cmd, exited <-chan struct{}) {
+	if err := signalGroup(cmd, syscall.SIGTERM); err != nil {
+		return
+	}
+
+	grace := cfg.ShutdownGrace
+	if grace <= 0 {
+		grace = defaultShutdownGrace
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(grace):
+		// if the child exited between the SIGTERM above and here, cmd.Wait
+		// has already reaped it and this is a harmless no-op (ESRCH) rather
+		// than a signal landing on a reused pgid.
+		_ = signalGroup(cmd, syscall.SIGKILL)
+	}
+}
+
+// signalGroup sends sig to the child's whole process group (negative pid),
+// relying on Setpgid having been set on cmd.SysProcAttr at launch.
+func signalGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	return syscall.Kill(-cmd.Process.Pid, sig)
+}
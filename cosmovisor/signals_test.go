@@ -0,0 +1,68 @@
+package cosmovisor
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func startGroupedCmd(t *testing.T, script string) *exec.Cmd {
+	t.Helper()
+
+	cmd := exec.Command("sh", "-c", script)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("starting scripted child: %v", err)
+	}
+	return cmd
+}
+
+func TestGracefulShutdownKillsAfterGraceTimeout(t *testing.T) {
+	// ignores SIGTERM, so cosmovisor has to escalate to SIGKILL
+	cmd := startGroupedCmd(t, `trap '' TERM; sleep 5`)
+	cfg := &Config{ShutdownGrace: 150 * time.Millisecond}
+
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	start := time.Now()
+	gracefulShutdown(cfg, cmd, exited)
+	<-exited
+	elapsed := time.Since(start)
+
+	if elapsed < cfg.ShutdownGrace {
+		t.Errorf("child was killed before the grace period elapsed: %s < %s", elapsed, cfg.ShutdownGrace)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("child took too long to die after the grace period: %s", elapsed)
+	}
+}
+
+func TestGracefulShutdownLetsChildExitEarly(t *testing.T) {
+	// exits promptly on SIGTERM, so cosmovisor should never need SIGKILL
+	cmd := startGroupedCmd(t, `trap 'exit 0' TERM; sleep 5 & wait`)
+	cfg := &Config{ShutdownGrace: 5 * time.Second}
+
+	exited := make(chan struct{})
+	var waitErr error
+	go func() {
+		waitErr = cmd.Wait()
+		close(exited)
+	}()
+
+	start := time.Now()
+	gracefulShutdown(cfg, cmd, exited)
+	<-exited
+	elapsed := time.Since(start)
+
+	if waitErr != nil {
+		t.Errorf("expected clean exit, got: %v", waitErr)
+	}
+	if elapsed >= cfg.ShutdownGrace {
+		t.Errorf("child exit was not noticed until the full grace period passed: %s", elapsed)
+	}
+}
@@ -0,0 +1,180 @@
+package cosmovisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+const (
+	// stderrTailSize bounds how much of a crashing child's stderr we keep
+	// around for fatal-error classification.
+	stderrTailSize = 4 << 10
+
+	baseRestartBackoff            = time.Second
+	defaultMaxRestartBackoff      = time.Minute
+	defaultMaxConsecutiveFailures = 10
+)
+
+// defaultFatalExitCodes covers exit codes cosmos SDK binaries commonly use
+// for unrecoverable conditions, such as a panic that reached the top-level
+// recover in tendermint's node runner. Operators can override this set via
+// Config.FatalExitCodes.
+var defaultFatalExitCodes = []int{2}
+
+// fatalStderrPattern matches stderr tails that mean the child isn't going to
+// get healthier on retry - corrupted state or a broken invariant - as
+// opposed to a transient error like a dropped peer connection.
+var fatalStderrPattern = regexp.MustCompile(`(?i)(panic:|invariant broken|CONSENSUS FAILURE|wrong Block\.Header)`)
+
+// FailureReport is written to $DAEMON_HOME/cosmovisor/failure.json once the
+// supervisor gives up, so operators and alerting have something durable to
+// inspect after the fact.
+type FailureReport struct {
+	Time             time.Time `json:"time"`
+	ConsecutiveFails int       `json:"consecutive_fails"`
+	LastExitError    string    `json:"last_exit_error"`
+	LastStderrTail   string    `json:"last_stderr_tail"`
+}
+
+// RunAndSuperviseCrashes runs LaunchProcess in a loop, restarting the child
+// with exponential backoff after a non-upgrade exit. It gives up - returning
+// an error instead of restarting again - once the child's exit looks fatal
+// (see isFatal) or the child has failed Config.MaxConsecutiveFailures times
+// in a row (the first launch included), whichever comes first. A successful upgrade (LaunchProcess
+// returning true) or a clean exit of a short-lived command both return
+// immediately, same as a single LaunchProcess call would.
+func RunAndSuperviseCrashes(cfg *Config, args []string, stdout, stderr io.Writer) (bool, error) {
+	var consecutiveFails int
+
+	for {
+		tail := &tailWriter{limit: stderrTailSize}
+
+		upgraded, err := LaunchProcess(cfg, args, stdout, io.MultiWriter(stderr, tail))
+		if upgraded {
+			return true, err
+		}
+		if err == nil {
+			return false, nil
+		}
+		if ShutdownRequested() {
+			// the child was killed because an operator sent cosmovisor
+			// SIGINT/SIGTERM, not because it crashed - don't restart it.
+			return false, nil
+		}
+
+		stderrTail := tail.String()
+
+		if isFatal(cfg, err, stderrTail) {
+			writeFailureReport(cfg, consecutiveFails+1, err, stderrTail)
+			return false, fmt.Errorf("child process exited fatally, giving up: %w", err)
+		}
+
+		consecutiveFails++
+		if consecutiveFails >= maxConsecutiveFailures(cfg) {
+			writeFailureReport(cfg, consecutiveFails, err, stderrTail)
+			return false, fmt.Errorf("giving up after %d consecutive failures, last error: %w", consecutiveFails, err)
+		}
+
+		time.Sleep(restartBackoff(cfg, consecutiveFails))
+	}
+}
+
+// isFatal reports whether err/stderrTail indicate a crash that restarting
+// won't fix: the exit code is one of Config.FatalExitCodes (or
+// defaultFatalExitCodes if unset), or the stderr tail matches
+// fatalStderrPattern.
+func isFatal(cfg *Config, err error, stderrTail string) bool {
+	codes := cfg.FatalExitCodes
+	if len(codes) == 0 {
+		codes = defaultFatalExitCodes
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		code := exitErr.ExitCode()
+		for _, c := range codes {
+			if code == c {
+				return true
+			}
+		}
+	}
+
+	return fatalStderrPattern.MatchString(stderrTail)
+}
+
+// restartBackoff returns how long to sleep before the attempt'th restart
+// (1-indexed), doubling each time up to Config.MaxRestartBackoff (or
+// defaultMaxRestartBackoff if unset), with up to 50% jitter to avoid a
+// thundering herd when many validators restart the same chain binary at once.
+func restartBackoff(cfg *Config, attempt int) time.Duration {
+	max := cfg.MaxRestartBackoff
+	if max <= 0 {
+		max = defaultMaxRestartBackoff
+	}
+
+	d := baseRestartBackoff << uint(attempt-1)
+	if d <= 0 || d > max {
+		d = max
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(d)/2 + 1))
+	return d/2 + jitter
+}
+
+func maxConsecutiveFailures(cfg *Config) int {
+	if cfg.MaxConsecutiveFailures > 0 {
+		return cfg.MaxConsecutiveFailures
+	}
+	return defaultMaxConsecutiveFailures
+}
+
+func writeFailureReport(cfg *Config, fails int, lastErr error, stderrTail string) {
+	report := FailureReport{
+		Time:             time.Now(),
+		ConsecutiveFails: fails,
+		LastExitError:    lastErr.Error(),
+		LastStderrTail:   stderrTail,
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Printf("error marshaling failure report: %v\n", err)
+		return
+	}
+
+	path := filepath.Join(cfg.Home, "cosmovisor", "failure.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		fmt.Printf("error creating cosmovisor dir for failure report: %v\n", err)
+		return
+	}
+	if err := ioutil.WriteFile(path, data, 0o644); err != nil {
+		fmt.Printf("error writing failure report: %v\n", err)
+	}
+}
+
+// tailWriter keeps only the last limit bytes ever written to it, so we can
+// pattern-match a crashing child's stderr without buffering the whole
+// stream.
+type tailWriter struct {
+	limit int
+	buf   []byte
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.limit {
+		t.buf = t.buf[len(t.buf)-t.limit:]
+	}
+	return len(p), nil
+}
+
+func (t *tailWriter) String() string {
+	return string(t.buf)
+}
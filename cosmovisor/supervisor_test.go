@@ -0,0 +1,126 @@
+package cosmovisor
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// fakeExitError builds an *exec.ExitError with the given exit code by
+// actually running a scripted child, since ExitError has no public
+// constructor.
+func fakeExitError(t *testing.T, code int) error {
+	t.Helper()
+
+	cmd := exec.Command("sh", "-c", "exit "+itoa(code))
+	err := cmd.Run()
+	if err == nil {
+		t.Fatalf("expected sh -c 'exit %d' to fail", code)
+	}
+	return err
+}
+
+func itoa(i int) string {
+	if i == 0 {
+		return "0"
+	}
+	neg := i < 0
+	if neg {
+		i = -i
+	}
+	var buf []byte
+	for i > 0 {
+		buf = append([]byte{byte('0' + i%10)}, buf...)
+		i /= 10
+	}
+	if neg {
+		buf = append([]byte{'-'}, buf...)
+	}
+	return string(buf)
+}
+
+func TestIsFatalExitCode(t *testing.T) {
+	cfg := &Config{FatalExitCodes: []int{2}}
+
+	if !isFatal(cfg, fakeExitError(t, 2), "") {
+		t.Error("exit code 2 should be classified fatal")
+	}
+	if isFatal(cfg, fakeExitError(t, 1), "") {
+		t.Error("exit code 1 should not be classified fatal")
+	}
+}
+
+func TestIsFatalDefaultExitCodes(t *testing.T) {
+	cfg := &Config{}
+
+	if !isFatal(cfg, fakeExitError(t, 2), "") {
+		t.Error("exit code 2 should be fatal by default")
+	}
+	if isFatal(cfg, fakeExitError(t, 137), "") {
+		t.Error("exit code 137 (SIGKILL) should not be fatal by default")
+	}
+}
+
+func TestIsFatalStderrPattern(t *testing.T) {
+	cfg := &Config{}
+	err := fakeExitError(t, 1)
+
+	cases := []struct {
+		tail  string
+		fatal bool
+	}{
+		{"panic: runtime error: invalid memory address", true},
+		{"CONSENSUS FAILURE!!! err=wrong Block.Header", true},
+		{"connection refused, retrying", false},
+	}
+
+	for _, tc := range cases {
+		if got := isFatal(cfg, err, tc.tail); got != tc.fatal {
+			t.Errorf("isFatal(%q) = %v, want %v", tc.tail, got, tc.fatal)
+		}
+	}
+}
+
+func TestRestartBackoffGrowsAndCaps(t *testing.T) {
+	cfg := &Config{MaxRestartBackoff: 8 * time.Second}
+
+	// each draw lies in [d/2, d] for the uncapped delay d, so below the cap
+	// a draw is never smaller than the one before it (attempt 1 is 0.5-1s,
+	// attempt 2 is 1-2s). Once capped, consecutive attempts share the same
+	// [cap/2, cap] range and a later draw can be the smaller one, so growth
+	// is only checked while uncapped.
+	var prev time.Duration
+	for attempt := 1; attempt <= 6; attempt++ {
+		d := restartBackoff(cfg, attempt)
+		if d <= 0 || d > cfg.MaxRestartBackoff {
+			t.Fatalf("attempt %d: backoff %s out of range (0, %s]", attempt, d, cfg.MaxRestartBackoff)
+		}
+		if baseRestartBackoff<<uint(attempt-1) <= cfg.MaxRestartBackoff && d < prev {
+			t.Errorf("attempt %d: backoff %s shrank from %s before reaching the cap", attempt, d, prev)
+		}
+		prev = d
+	}
+
+	// a very large attempt count (base<<(attempt-1) overflows int64) must
+	// still land within the cap instead of going negative or zero.
+	if d := restartBackoff(cfg, 40); d <= 0 || d > cfg.MaxRestartBackoff {
+		t.Errorf("attempt 40: backoff %s out of range (0, %s]", d, cfg.MaxRestartBackoff)
+	}
+}
+
+func TestMaxConsecutiveFailuresDefault(t *testing.T) {
+	if got := maxConsecutiveFailures(&Config{}); got != defaultMaxConsecutiveFailures {
+		t.Errorf("maxConsecutiveFailures(&Config{}) = %d, want %d", got, defaultMaxConsecutiveFailures)
+	}
+	if got := maxConsecutiveFailures(&Config{MaxConsecutiveFailures: 3}); got != 3 {
+		t.Errorf("maxConsecutiveFailures(&Config{MaxConsecutiveFailures: 3}) = %d, want 3", got)
+	}
+}
+
+func TestTailWriterKeepsOnlyLastBytes(t *testing.T) {
+	tw := &tailWriter{limit: 5}
+	_, _ = tw.Write([]byte("hello world"))
+	if got := tw.String(); got != "world" {
+		t.Errorf("tailWriter.String() = %q, want %q", got, "world")
+	}
+}